@@ -0,0 +1,306 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netutil implements network utility functions, complementing
+// the more common ones in the net package.
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+var (
+	plog = capnslog.NewPackageLogger("github.com/coreos/etcd", "pkg/netutil")
+
+	// resolver is the indirection point used by tests to stub out DNS
+	// resolution. Production code resolves through net.DefaultResolver so
+	// that platform facilities such as /etc/resolv.conf timeouts are
+	// honored.
+	resolver Resolver = &netResolver{}
+)
+
+// Resolver resolves host to one of its IP addresses, bounded by ctx.
+type Resolver interface {
+	ResolveIPAddr(ctx context.Context, network, host string) (*net.IPAddr, error)
+}
+
+type netResolver struct{}
+
+func (r *netResolver) ResolveIPAddr(ctx context.Context, network, host string) (*net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	return &addrs[0], nil
+}
+
+// ResolveError describes the failure to resolve a single URL during a
+// ResolveTCPAddrs(Context) call. It names the offending URL and the
+// underlying DNS error so operators can tell which peer in a large
+// cluster is misconfigured, and flags whether the failure was caused by
+// the resolution deadline expiring rather than a genuine DNS error.
+type ResolveError struct {
+	URL       string
+	Err       error
+	IsTimeout bool
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("failed to resolve %q (%v)", e.URL, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error { return e.Err }
+
+// ResolveTCPAddrs is a convenience wrapper around ResolveTCPAddrsContext
+// that resolves without a deadline.
+func ResolveTCPAddrs(urls ...[]url.URL) error {
+	return ResolveTCPAddrsContext(context.Background(), urls...)
+}
+
+// ResolveTCPAddrsContext resolves the hostname of every URL in urls,
+// replacing it with its resolved address so that the same logical
+// address with different hostnames doesn't cause a spurious config
+// change. Resolution honors ctx, so callers can bound how long DNS
+// lookups are allowed to run; the URLs within a single group are
+// resolved concurrently via an errgroup, and the first failure aborts
+// the remaining lookups in that group and is returned as a
+// *ResolveError naming the URL that failed.
+func ResolveTCPAddrsContext(ctx context.Context, urls ...[]url.URL) error {
+	for _, us := range urls {
+		g, gctx := errgroup.WithContext(ctx)
+		for i := range us {
+			i, u := i, us[i]
+			g.Go(func() error {
+				nu, err := url.Parse(u.String())
+				if err != nil {
+					return &ResolveError{URL: u.String(), Err: err}
+				}
+				h, rerr := resolveHost(gctx, nu)
+				if rerr != nil {
+					return &ResolveError{URL: u.String(), Err: rerr, IsTimeout: gctx.Err() == context.DeadlineExceeded}
+				}
+				if h != "" {
+					nu.Host = h
+				}
+				us[i] = *nu
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveHost resolves the host portion of u's Host field and, if it
+// resolved to something other than what was already there, returns the
+// new "host:port" string to substitute. It returns an empty string with
+// a nil error when no substitution is necessary (unix sockets, literal
+// IPs, localhost).
+func resolveHost(ctx context.Context, u *url.URL) (string, error) {
+	if u.Scheme == "unix" || u.Scheme == "unixs" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", fmt.Errorf("error parsing url %s during tcp resolving: %v", u.Host, err)
+	}
+	if host == "localhost" {
+		return "", nil
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		// Already a literal IP (including zone-scoped IPv6, which
+		// net.ParseIP does not recognize), so no resolution needed.
+		return "", nil
+	}
+	addr, err := resolver.ResolveIPAddr(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %v", u.Host, err)
+	}
+	return net.JoinHostPort(addr.String(), port), nil
+}
+
+// URLsEqual checks equality of url.URLS between two arrays.
+// This check passes even if an URL is in hostname and the opposite is in
+// IP address, or the two differ only in IPv6 representation or in
+// whether a zone identifier is present (e.g. "[::1]:2379" equals
+// "[0:0:0:0:0:0:0:1]:2379", and "fe80::1%eth0" equals "fe80::1" when
+// only one side specifies a zone). A sort-and-pair step makes the
+// result independent of input order as long as every element resolves
+// to a unique endpoint.
+func URLsEqual(a []url.URL, b []url.URL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ka, err := canonicalEndpoints(a)
+	if err != nil {
+		return false
+	}
+	kb, err := canonicalEndpoints(b)
+	if err != nil {
+		return false
+	}
+	sort.Slice(ka, func(i, j int) bool { return ka[i].sortKey() < ka[j].sortKey() })
+	sort.Slice(kb, func(i, j int) bool { return kb[i].sortKey() < kb[j].sortKey() })
+	for i := range ka {
+		if !ka[i].equal(kb[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanonicalizeURLs resolves and normalizes each URL in urls so that
+// cosmetic DNS or IPv6-formatting differences don't cause spurious
+// configuration changes when the result is compared or persisted, e.g.
+// to the Raft log of cluster members.
+func CanonicalizeURLs(urls []url.URL) ([]url.URL, error) {
+	out := make([]url.URL, len(urls))
+	for i, u := range urls {
+		k, err := canonicalEndpoint(u)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = u
+		if k.isIP {
+			if k.port != "" {
+				out[i].Host = net.JoinHostPort(k.addr.String(), k.port)
+			} else {
+				out[i].Host = k.addr.String()
+			}
+		}
+	}
+	return out, nil
+}
+
+// URLStringsEqual parses URLs in the string slices and checks that the
+// parsed URLs are equal.
+func URLStringsEqual(a []string, b []string) bool {
+	urlsA, err := stringsToURLs(a)
+	if err != nil {
+		plog.Errorf("failed to parse %q: %v", a, err)
+		return false
+	}
+	urlsB, err := stringsToURLs(b)
+	if err != nil {
+		plog.Errorf("failed to parse %q: %v", b, err)
+		return false
+	}
+	return URLsEqual(urlsA, urlsB)
+}
+
+func stringsToURLs(strs []string) ([]url.URL, error) {
+	urls := make([]url.URL, len(strs))
+	for i, str := range strs {
+		u, err := url.Parse(str)
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = *u
+	}
+	return urls, nil
+}
+
+// endpointKey is the canonicalized form of a resolved URL endpoint used
+// to compare two URLs for equality regardless of superficial formatting
+// differences. IP hosts are parsed into a netip.Addr, which folds all
+// equivalent IPv6 representations (e.g. "::1" and
+// "0:0:0:0:0:0:0:1") into a single comparable value and keeps any zone
+// identifier (e.g. the "eth0" in "fe80::1%eth0") alongside it.
+type endpointKey struct {
+	scheme string
+	host   string // non-IP host, e.g. an unresolved hostname or a unix socket path
+	addr   netip.Addr
+	port   string
+	isIP   bool
+}
+
+func canonicalEndpoints(urls []url.URL) ([]endpointKey, error) {
+	keys := make([]endpointKey, len(urls))
+	for i, u := range urls {
+		k, err := canonicalEndpoint(u)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
+func canonicalEndpoint(u url.URL) (endpointKey, error) {
+	resolved := u
+	h, err := resolveHost(context.Background(), &u)
+	if err != nil {
+		return endpointKey{}, err
+	}
+	if h != "" {
+		resolved.Host = h
+	}
+
+	host, port, err := net.SplitHostPort(resolved.Host)
+	if err != nil {
+		return endpointKey{scheme: u.Scheme, host: resolved.Host}, nil
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return endpointKey{scheme: u.Scheme, host: host, port: port}, nil
+	}
+	return endpointKey{scheme: u.Scheme, addr: addr, port: port, isIP: true}, nil
+}
+
+// equal reports whether k and o refer to the same endpoint. A zone
+// identifier is only required to match when both sides specify one;
+// when only one side has a zone, the zone is ignored so that e.g.
+// "fe80::1" and "fe80::1%eth0" compare equal.
+func (k endpointKey) equal(o endpointKey) bool {
+	if k.scheme != o.scheme || k.port != o.port || k.isIP != o.isIP {
+		return false
+	}
+	if !k.isIP {
+		return k.host == o.host
+	}
+	if k.addr.Zone() != "" && o.addr.Zone() != "" && k.addr.Zone() != o.addr.Zone() {
+		return false
+	}
+	return k.addr.WithZone("") == o.addr.WithZone("")
+}
+
+// sortKey must primarily sort by the same zone-folded identity that
+// equal() uses to decide a match, so a zoned entry and its zoneless
+// counterpart at different ports don't sort into mismatched positions
+// across the two lists. The zone is appended as a secondary tiebreaker:
+// without it, two entries that collide on zone-folded address+port but
+// carry different, mutually non-matching zones (e.g. the same link-local
+// address advertised over two interfaces) would sort as "equal" and
+// end up in an unspecified relative order (sort.Slice is not stable),
+// which isn't guaranteed to line up the same way across both lists.
+func (k endpointKey) sortKey() string {
+	if !k.isIP {
+		return k.scheme + "|h|" + k.host + "|" + k.port
+	}
+	return k.scheme + "|a|" + k.addr.WithZone("").String() + "|" + k.port + "|" + k.addr.Zone()
+}
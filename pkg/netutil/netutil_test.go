@@ -15,16 +15,37 @@
 package netutil
 
 import (
+	"context"
 	"errors"
 	"net"
 	"net/url"
 	"reflect"
-	"strconv"
 	"testing"
+	"time"
 )
 
+type fakeResolver struct {
+	hostMap map[string]string
+	delay   time.Duration
+}
+
+func (r *fakeResolver) ResolveIPAddr(ctx context.Context, network, host string) (*net.IPAddr, error) {
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	ip, ok := r.hostMap[host]
+	if !ok || ip == "" {
+		return nil, errors.New("cannot resolve host.")
+	}
+	return &net.IPAddr{IP: net.ParseIP(ip)}, nil
+}
+
 func TestResolveTCPAddrs(t *testing.T) {
-	defer func() { resolveTCPAddr = net.ResolveTCPAddr }()
+	defer func() { resolver = &netResolver{} }()
 	tests := []struct {
 		urls     [][]url.URL
 		expected [][]url.URL
@@ -110,20 +131,7 @@ func TestResolveTCPAddrs(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		resolveTCPAddr = func(network, addr string) (*net.TCPAddr, error) {
-			host, port, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-			if tt.hostMap[host] == "" {
-				return nil, errors.New("cannot resolve host.")
-			}
-			i, err := strconv.Atoi(port)
-			if err != nil {
-				return nil, err
-			}
-			return &net.TCPAddr{IP: net.ParseIP(tt.hostMap[host]), Port: i, Zone: ""}, nil
-		}
+		resolver = &fakeResolver{hostMap: tt.hostMap}
 		err := ResolveTCPAddrs(tt.urls...)
 		if tt.hasError {
 			if err == nil {
@@ -137,19 +145,54 @@ func TestResolveTCPAddrs(t *testing.T) {
 	}
 }
 
-func TestURLsEqual(t *testing.T) {
-	defer func() { resolveTCPAddr = net.ResolveTCPAddr }()
-	resolveTCPAddr = func(network, addr string) (*net.TCPAddr, error) {
-		host, port, err := net.SplitHostPort(addr)
-		if host != "example.com" {
-			return nil, errors.New("cannot resolve host.")
-		}
-		i, err := strconv.Atoi(port)
-		if err != nil {
-			return nil, err
-		}
-		return &net.TCPAddr{IP: net.ParseIP("10.0.10.1"), Port: i, Zone: ""}, nil
+func TestResolveTCPAddrsContextReportsOffendingURL(t *testing.T) {
+	defer func() { resolver = &netResolver{} }()
+	resolver = &fakeResolver{hostMap: map[string]string{"good.example.com": "10.0.1.10"}}
+
+	urls := [][]url.URL{
+		[]url.URL{
+			url.URL{Scheme: "http", Host: "good.example.com:2379"},
+			url.URL{Scheme: "http", Host: "bad.example.com:2380"},
+		},
+	}
+	err := ResolveTCPAddrsContext(context.Background(), urls...)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	rerr, ok := err.(*ResolveError)
+	if !ok {
+		t.Fatalf("expected *ResolveError, got %T", err)
+	}
+	if rerr.URL != "http://bad.example.com:2380" {
+		t.Errorf("unexpected offending URL: %s", rerr.URL)
 	}
+}
+
+func TestResolveTCPAddrsContextTimeout(t *testing.T) {
+	defer func() { resolver = &netResolver{} }()
+	resolver = &fakeResolver{hostMap: map[string]string{"slow.example.com": "10.0.1.10"}, delay: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	urls := [][]url.URL{
+		[]url.URL{url.URL{Scheme: "http", Host: "slow.example.com:2379"}},
+	}
+	err := ResolveTCPAddrsContext(ctx, urls...)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	rerr, ok := err.(*ResolveError)
+	if !ok {
+		t.Fatalf("expected *ResolveError, got %T", err)
+	}
+	if !rerr.IsTimeout {
+		t.Errorf("expected IsTimeout, got %+v", rerr)
+	}
+}
+
+func TestURLsEqual(t *testing.T) {
+	defer func() { resolver = &netResolver{} }()
+	resolver = &fakeResolver{hostMap: map[string]string{"example.com": "10.0.10.1"}}
 
 	tests := []struct {
 		a      []url.URL
@@ -235,6 +278,105 @@ func TestURLsEqual(t *testing.T) {
 		}
 	}
 }
+
+func TestURLsEqualIPv6AndZone(t *testing.T) {
+	tests := []struct {
+		a      []url.URL
+		b      []url.URL
+		expect bool
+	}{
+		{
+			a:      []url.URL{{Scheme: "http", Host: "[::1]:2379"}},
+			b:      []url.URL{{Scheme: "http", Host: "[0:0:0:0:0:0:0:1]:2379"}},
+			expect: true,
+		},
+		{
+			a:      []url.URL{{Scheme: "http", Host: "[fe80::1%eth0]:2380"}},
+			b:      []url.URL{{Scheme: "http", Host: "[fe80::1]:2380"}},
+			expect: true,
+		},
+		{
+			a:      []url.URL{{Scheme: "http", Host: "[fe80::1%eth0]:2380"}},
+			b:      []url.URL{{Scheme: "http", Host: "[fe80::1%eth1]:2380"}},
+			expect: false,
+		},
+		{
+			a: []url.URL{
+				{Scheme: "http", Host: "[::1]:2379"},
+				{Scheme: "http", Host: "127.0.0.1:2380"},
+			},
+			b: []url.URL{
+				{Scheme: "http", Host: "127.0.0.1:2380"},
+				{Scheme: "http", Host: "[0:0:0:0:0:0:0:1]:2379"},
+			},
+			expect: true,
+		},
+		{
+			// A zoned and a zoneless entry for the same address at
+			// different ports must still sort into matching pairs.
+			a: []url.URL{
+				{Scheme: "http", Host: "[fe80::1%eth0]:2380"},
+				{Scheme: "http", Host: "[fe80::1]:2379"},
+			},
+			b: []url.URL{
+				{Scheme: "http", Host: "[fe80::1]:2380"},
+				{Scheme: "http", Host: "[fe80::1%eth0]:2379"},
+			},
+			expect: true,
+		},
+		{
+			// Same two URLs, reordered: a dual-homed peer advertised
+			// over two link-local interfaces at the same port.
+			a: []url.URL{
+				{Scheme: "http", Host: "[fe80::1%eth0]:2380"},
+				{Scheme: "http", Host: "[fe80::1%eth1]:2380"},
+			},
+			b: []url.URL{
+				{Scheme: "http", Host: "[fe80::1%eth1]:2380"},
+				{Scheme: "http", Host: "[fe80::1%eth0]:2380"},
+			},
+			expect: true,
+		},
+	}
+	for _, tt := range tests {
+		if result := URLsEqual(tt.a, tt.b); result != tt.expect {
+			t.Errorf("a:%v b:%v, expected %v but %v", tt.a, tt.b, tt.expect, result)
+		}
+	}
+}
+
+func TestResolveTCPAddrsContextZoneScopedLiteralNeedsNoResolver(t *testing.T) {
+	defer func() { resolver = &netResolver{} }()
+	// An empty fakeResolver stands in for any Resolver with no special
+	// literal-IP fast path; a zone-scoped IPv6 literal must be
+	// recognized without ever consulting it.
+	resolver = &fakeResolver{}
+
+	urls := [][]url.URL{
+		[]url.URL{url.URL{Scheme: "http", Host: "[fe80::1%eth0]:2380"}},
+	}
+	if err := ResolveTCPAddrsContext(context.Background(), urls...); err != nil {
+		t.Fatalf("unexpected error resolving zone-scoped literal: %v", err)
+	}
+}
+
+func TestCanonicalizeURLs(t *testing.T) {
+	in := []url.URL{
+		{Scheme: "http", Host: "[0:0:0:0:0:0:0:1]:2379"},
+		{Scheme: "http", Host: "127.0.0.1:2380"},
+	}
+	out, err := CanonicalizeURLs(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out[0].Host != "[::1]:2379" {
+		t.Errorf("expected canonical IPv6 form, got %s", out[0].Host)
+	}
+	if out[1].Host != "127.0.0.1:2380" {
+		t.Errorf("unexpected host: %s", out[1].Host)
+	}
+}
+
 func TestURLStringsEqual(t *testing.T) {
 	result := URLStringsEqual([]string{"http://127.0.0.1:8080"}, []string{"http://127.0.0.1:8080"})
 	if !result {
@@ -0,0 +1,150 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV is an indirection point for tests to stub out DNS SRV
+// lookups.
+var lookupSRV = net.LookupSRV
+
+// SRVError describes the failure of one step of SRV-based cluster
+// discovery, naming the step (the SRV lookup itself, the check of a
+// target against the advertised peer URLs, or the A/AAAA lookup of one
+// of its targets) and the name being looked up so
+// operators can tell a misconfigured zone from a genuinely unreachable
+// target.
+type SRVError struct {
+	Step string
+	Name string
+	Err  error
+}
+
+func (e *SRVError) Error() string {
+	return fmt.Sprintf("netutil: SRV discovery failed at %s for %q: %v", e.Step, e.Name, e.Err)
+}
+
+func (e *SRVError) Unwrap() error { return e.Err }
+
+// SRVGetCluster performs a DNS SRV lookup for "_<service>._tcp.<domain>"
+// (e.g. service "etcd-server" for peer discovery, "etcd-client" for
+// client discovery) and resolves the returned targets into a list of
+// URLs, along with a matching --initial-cluster token of the form
+// "target0=url0,target1=url1,...".
+//
+// Every target is validated against apurls: a target that does not
+// match the host of one of the member's own advertised peer URLs is
+// rejected, since it cannot be trusted to be part of this cluster.
+// Targets are ordered by SRV priority, then by a weighted random choice
+// within equal priority as described by RFC 2782, and duplicate targets
+// that resolve to the same IP:port are dropped.
+func SRVGetCluster(service, domain string, apurls []url.URL) ([]url.URL, string, error) {
+	if len(apurls) == 0 {
+		return nil, "", fmt.Errorf("netutil: SRVGetCluster requires at least one advertised peer URL")
+	}
+	scheme := apurls[0].Scheme
+
+	_, srvs, err := lookupSRV(service, "tcp", domain)
+	if err != nil {
+		return nil, "", &SRVError{Step: "SRV lookup", Name: domain, Err: err}
+	}
+
+	known := make(map[string]bool, len(apurls))
+	for _, u := range apurls {
+		host, _, herr := net.SplitHostPort(u.Host)
+		if herr != nil {
+			continue
+		}
+		known[strings.ToLower(host)] = true
+	}
+
+	urls := make([]url.URL, 0, len(srvs))
+	tokens := make([]string, 0, len(srvs))
+	seen := make(map[string]bool, len(srvs))
+	for _, srv := range orderSRVTargets(srvs) {
+		target := strings.TrimSuffix(srv.Target, ".")
+		if !known[strings.ToLower(target)] {
+			return nil, "", &SRVError{Step: "validate target", Name: target, Err: fmt.Errorf("target is not one of the advertised peer URLs")}
+		}
+		addr, rerr := resolver.ResolveIPAddr(context.Background(), "ip", target)
+		if rerr != nil {
+			return nil, "", &SRVError{Step: "A/AAAA lookup", Name: target, Err: rerr}
+		}
+		key := net.JoinHostPort(addr.String(), strconv.Itoa(int(srv.Port)))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(target, strconv.Itoa(int(srv.Port)))}
+		urls = append(urls, u)
+		tokens = append(tokens, fmt.Sprintf("%s=%s", target, u.String()))
+	}
+
+	return urls, strings.Join(tokens, ","), nil
+}
+
+// orderSRVTargets sorts srvs by priority ascending, breaking ties within
+// a priority group with a weighted random selection as described by
+// RFC 2782 section 2.
+func orderSRVTargets(srvs []*net.SRV) []*net.SRV {
+	sorted := append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	ordered := make([]*net.SRV, 0, len(sorted))
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+			j++
+		}
+		ordered = append(ordered, weightedShuffle(sorted[i:j])...)
+		i = j
+	}
+	return ordered
+}
+
+// weightedShuffle repeatedly draws from group without replacement,
+// weighting each draw by (Weight+1) so that zero-weight records still
+// have a chance of being picked, as RFC 2782 requires.
+func weightedShuffle(group []*net.SRV) []*net.SRV {
+	remaining := append([]*net.SRV(nil), group...)
+	out := make([]*net.SRV, 0, len(group))
+	for len(remaining) > 0 {
+		total := 0
+		for _, s := range remaining {
+			total += int(s.Weight) + 1
+		}
+		pick := rand.Intn(total)
+		idx := 0
+		for ; idx < len(remaining)-1; idx++ {
+			pick -= int(remaining[idx].Weight) + 1
+			if pick < 0 {
+				break
+			}
+		}
+		out = append(out, remaining[idx])
+		remaining = append(remaining[:idx], remaining[idx+1:]...)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutil
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func TestSRVGetCluster(t *testing.T) {
+	defer func() {
+		lookupSRV = net.LookupSRV
+		resolver = &netResolver{}
+	}()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if service != "etcd-server" || name != "example.com" {
+			t.Fatalf("unexpected lookup: service=%s proto=%s name=%s", service, proto, name)
+		}
+		return "", []*net.SRV{
+			{Target: "infra0.example.com.", Port: 2380, Priority: 1, Weight: 1},
+			{Target: "infra1.example.com.", Port: 2380, Priority: 1, Weight: 1},
+		}, nil
+	}
+	resolver = &fakeResolver{hostMap: map[string]string{
+		"infra0.example.com": "10.0.1.10",
+		"infra1.example.com": "10.0.1.11",
+	}}
+
+	apurls := []url.URL{
+		{Scheme: "http", Host: "infra0.example.com:2380"},
+		{Scheme: "http", Host: "infra1.example.com:2380"},
+	}
+
+	urls, token, err := SRVGetCluster("etcd-server", "example.com", apurls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 2 {
+		t.Fatalf("expected 2 urls, got %d: %v", len(urls), urls)
+	}
+	hosts := []string{urls[0].Host, urls[1].Host}
+	sort.Strings(hosts)
+	if hosts[0] != "infra0.example.com:2380" || hosts[1] != "infra1.example.com:2380" {
+		t.Errorf("unexpected urls: %v", urls)
+	}
+	if token == "" {
+		t.Errorf("expected non-empty initial-cluster token")
+	}
+}
+
+func TestSRVGetClusterRejectsUnknownTarget(t *testing.T) {
+	defer func() {
+		lookupSRV = net.LookupSRV
+		resolver = &netResolver{}
+	}()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{
+			{Target: "evil.attacker.com.", Port: 2380, Priority: 1, Weight: 1},
+		}, nil
+	}
+	resolver = &fakeResolver{hostMap: map[string]string{"evil.attacker.com": "1.2.3.4"}}
+
+	apurls := []url.URL{{Scheme: "http", Host: "infra0.example.com:2380"}}
+	if _, _, err := SRVGetCluster("etcd-server", "example.com", apurls); err == nil {
+		t.Fatal("expected error for target not in advertised peer URLs")
+	}
+}
+
+func TestSRVGetClusterTargetMatchIsCaseInsensitive(t *testing.T) {
+	defer func() {
+		lookupSRV = net.LookupSRV
+		resolver = &netResolver{}
+	}()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{
+			{Target: "Infra0.Example.com.", Port: 2380, Priority: 1, Weight: 1},
+		}, nil
+	}
+	resolver = &fakeResolver{hostMap: map[string]string{"Infra0.Example.com": "10.0.1.10"}}
+
+	apurls := []url.URL{{Scheme: "http", Host: "infra0.example.com:2380"}}
+	if _, _, err := SRVGetCluster("etcd-server", "example.com", apurls); err != nil {
+		t.Fatalf("unexpected error for case-differing target: %v", err)
+	}
+}
+
+func TestSRVGetClusterDedupesSameAddress(t *testing.T) {
+	defer func() {
+		lookupSRV = net.LookupSRV
+		resolver = &netResolver{}
+	}()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{
+			{Target: "infra0.example.com.", Port: 2380, Priority: 1, Weight: 1},
+			{Target: "infra0-alias.example.com.", Port: 2380, Priority: 1, Weight: 1},
+		}, nil
+	}
+	resolver = &fakeResolver{hostMap: map[string]string{
+		"infra0.example.com":       "10.0.1.10",
+		"infra0-alias.example.com": "10.0.1.10",
+	}}
+
+	apurls := []url.URL{
+		{Scheme: "http", Host: "infra0.example.com:2380"},
+		{Scheme: "http", Host: "infra0-alias.example.com:2380"},
+	}
+	urls, _, err := SRVGetCluster("etcd-server", "example.com", apurls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(urls) != 1 {
+		t.Errorf("expected duplicate target to be deduped, got %v", urls)
+	}
+}
+
+func TestSRVGetClusterSurfacesLookupError(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+
+	apurls := []url.URL{{Scheme: "http", Host: "infra0.example.com:2380"}}
+	_, _, err := SRVGetCluster("etcd-server", "example.com", apurls)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	serr, ok := err.(*SRVError)
+	if !ok {
+		t.Fatalf("expected *SRVError, got %T", err)
+	}
+	if serr.Step != "SRV lookup" {
+		t.Errorf("unexpected step: %s", serr.Step)
+	}
+}